@@ -0,0 +1,140 @@
+package jq
+
+// #include <jq.h>
+// #include <jv.h>
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"runtime"
+	"unsafe"
+)
+
+// Decoder reads a stream of JSON values (NDJSON, or simply concatenated
+// JSON values with no separator) from an io.Reader, runs jq's program
+// against each one, and yields every result in turn. It is modeled after
+// encoding/json.Decoder, but an input value can expand into zero, one, or
+// many results depending on the program.
+type Decoder struct {
+	jq     *JQ
+	r      io.Reader
+	parser *C.jv_parser
+	buf    []byte
+
+	started bool // jq_start has been called for the current input value
+	ready   bool // a result is buffered and waiting to be read by Decode
+	eof     bool
+	err     error
+}
+
+// NewDecoder returns a Decoder that reads JSON values from r and applies
+// jq's program to each of them. Call Close when done with it to free the
+// underlying jv_parser.
+func (jq *JQ) NewDecoder(r io.Reader) *Decoder {
+	d := &Decoder{
+		jq:     jq,
+		r:      r,
+		parser: C.jv_parser_new(0),
+		buf:    make([]byte, 4096),
+	}
+	runtime.SetFinalizer(d, (*Decoder).Close)
+	return d
+}
+
+// Close frees the Decoder's underlying jv_parser. It does not close jq or
+// the underlying io.Reader. It is safe to call more than once.
+func (d *Decoder) Close() {
+	if d.parser == nil {
+		return
+	}
+	runtime.SetFinalizer(d, nil)
+	C.jv_parser_free(d.parser)
+	d.parser = nil
+}
+
+// More reports whether a subsequent call to Decode will produce a value.
+func (d *Decoder) More() bool {
+	if d.err != nil {
+		return false
+	}
+	if d.ready {
+		return true
+	}
+
+	for {
+		if d.started {
+			if d.jq.Next() {
+				d.ready = true
+				return true
+			}
+			d.started = false
+			if err := d.jq.Err(); err != nil {
+				d.err = err
+				return false
+			}
+		}
+
+		value, ok, err := d.nextInputValue()
+		if err != nil {
+			d.err = err
+			return false
+		}
+		if !ok {
+			return false
+		}
+
+		d.jq.start(value)
+		d.started = true
+	}
+}
+
+// Decode stores the next result of running jq's program against the input
+// stream in v, the same way json.Unmarshal would. It returns io.EOF once
+// the stream and the program's output are both exhausted.
+func (d *Decoder) Decode(v interface{}) error {
+	if !d.More() {
+		if d.err != nil {
+			return d.err
+		}
+		return io.EOF
+	}
+	d.ready = false
+	return json.Unmarshal([]byte(d.jq.ValueJson()), v)
+}
+
+// nextInputValue parses the next complete JSON value out of the stream,
+// reading further from r as the parser asks for more input.
+func (d *Decoder) nextInputValue() (*JV, bool, error) {
+	for {
+		value := C.jv_parser_next(d.parser)
+		if C.jv_is_valid(value) != 0 {
+			return &JV{value}, true, nil
+		}
+		if C.jv_invalid_has_msg(C.jv_copy(value)) != 0 {
+			err := errors.New(jvErrorMessage(value))
+			C.jv_free(value)
+			return nil, false, err
+		}
+		C.jv_free(value)
+
+		if d.eof {
+			return nil, false, nil
+		}
+
+		n, readErr := d.r.Read(d.buf)
+		isPartial := C.int(1)
+		if readErr == io.EOF {
+			d.eof = true
+			isPartial = 0
+		} else if readErr != nil {
+			return nil, false, readErr
+		}
+
+		var cBuf *C.char
+		if n > 0 {
+			cBuf = (*C.char)(unsafe.Pointer(&d.buf[0]))
+		}
+		C.jv_parser_set_buf(d.parser, cBuf, C.int(n), isPartial)
+	}
+}
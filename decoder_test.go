@@ -0,0 +1,43 @@
+package jq
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecoderNDJSON(t *testing.T) {
+	jq, err := NewJQ(".x")
+	ok(t, err)
+	defer jq.Close()
+
+	dec := jq.NewDecoder(strings.NewReader(`{"x":1}
+{"x":2}
+{"x":3}
+`))
+	defer dec.Close()
+
+	var got []int
+	for dec.More() {
+		var v int
+		ok(t, dec.Decode(&v))
+		got = append(got, v)
+	}
+	equals(t, []int{1, 2, 3}, got)
+}
+
+func TestDecoderExpandsEachValue(t *testing.T) {
+	jq, err := NewJQ(".[]")
+	ok(t, err)
+	defer jq.Close()
+
+	dec := jq.NewDecoder(strings.NewReader(`[1,2][3]`))
+	defer dec.Close()
+
+	var got []int
+	for dec.More() {
+		var v int
+		ok(t, dec.Decode(&v))
+		got = append(got, v)
+	}
+	equals(t, []int{1, 2, 3}, got)
+}
@@ -0,0 +1,89 @@
+package jq
+
+// #include <jv.h>
+import "C"
+import "strings"
+
+// jvPrintIndentShift mirrors jq's JV_PRINT_INDENT_FLAGS(n) macro. It can't
+// be used directly as C.JV_PRINT_INDENT_FLAGS because cgo only exposes
+// object-like #defines, not function-like ones.
+const jvPrintIndentShift = 8
+
+func jvPrintIndentFlags(n int) C.int {
+	if n > 7 {
+		n = 7 // jq's printer encodes at most 7 spaces per level
+	}
+	return C.int((n & 0x7) << jvPrintIndentShift)
+}
+
+// DumpOptions controls how ToJsonOpts renders a value, mirroring the flags
+// behind jq's --pretty-output, --sort-keys, --ascii-output, --color-output,
+// --tab and --indent CLI flags.
+type DumpOptions struct {
+	Pretty    bool
+	SortKeys  bool
+	Ascii     bool
+	Colorize  bool
+	TabIndent bool
+	Indent    int // spaces per level, capped at 7; ignored when TabIndent is set
+}
+
+func (o DumpOptions) flags() C.int {
+	var flags C.int
+	if o.Pretty {
+		flags |= C.JV_PRINT_PRETTY
+	}
+	if o.SortKeys {
+		flags |= C.JV_PRINT_SORTED
+	}
+	if o.Ascii {
+		flags |= C.JV_PRINT_ASCII
+	}
+	if o.Colorize {
+		flags |= C.JV_PRINT_COLOR
+	}
+	if o.TabIndent {
+		flags |= C.JV_PRINT_TAB
+	} else if o.Indent > 0 {
+		flags |= jvPrintIndentFlags(o.Indent)
+	}
+	return flags
+}
+
+// ToJsonOpts renders jv as JSON using the given DumpOptions.
+func (jv *JV) ToJsonOpts(opts DumpOptions) string {
+	strJv := C.jv_dump_string(C.jv_copy(jv.value), opts.flags())
+	defer C.jv_free(strJv)
+	return C.GoString(C.jv_string_value(strJv))
+}
+
+// ToJsonIndent renders jv as pretty-printed JSON, following the
+// json.MarshalIndent convention: indent sets the per-level indent (use
+// "\t" for a tab, otherwise its length in spaces, capped at 7), and
+// prefix is prepended to every line after the first.
+func (jv *JV) ToJsonIndent(prefix, indent string) string {
+	opts := DumpOptions{Pretty: true}
+	if indent == "\t" {
+		opts.TabIndent = true
+	} else {
+		opts.Indent = len(indent)
+	}
+
+	text := jv.ToJsonOpts(opts)
+	if prefix == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, "\n", "\n"+prefix)
+}
+
+// ValueJsonOpts renders the last value produced by Next as JSON using the
+// given DumpOptions.
+func (jq *JQ) ValueJsonOpts(opts DumpOptions) string {
+	return jq.lastValue.ToJsonOpts(opts)
+}
+
+// ValueJsonIndent renders the last value produced by Next as
+// pretty-printed JSON; see JV.ToJsonIndent.
+func (jq *JQ) ValueJsonIndent(prefix, indent string) string {
+	return jq.lastValue.ToJsonIndent(prefix, indent)
+}
@@ -1,30 +1,182 @@
 package jq
 
 // #cgo LDFLAGS: -ljq
+// #include <stdlib.h>
 // #include <jq.h>
 // #include <jv.h>
+//
+// extern void goJQErrorCallback(void *data, jv value);
 import "C"
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"unsafe"
 )
 
 type JQ struct {
 	program   string
 	state     *C.jq_state
 	lastValue *JV
+	errBuf    *errorBuffer
+	useNumber bool
+}
+
+// errorBuffer collects error messages reported through the jq_state's
+// error callback. It's a separate allocation from JQ (rather than a field
+// read directly by the callback) so the package-level errCallbacks
+// registry doesn't keep the JQ itself permanently reachable, which would
+// defeat newJQState's finalizer.
+type errorBuffer struct {
+	msgs []string
+}
+
+// UseNumber causes Value to decode JSON numbers as json.Number instead of
+// int/float64, preserving the exact digits jq parsed. Without it, integers
+// larger than 2^53 silently lose precision when routed through float64.
+//
+// This only preserves literal digits on jq >= 1.7; jq 1.6 and earlier
+// (e.g. Debian stable's libjq1) round every number through a float64
+// internally, so it will still lose precision on large integers even with
+// UseNumber enabled.
+func (jq *JQ) UseNumber() {
+	jq.useNumber = true
+}
+
+// errCallbacks maps a jq_state to the errorBuffer collecting its messages,
+// so the error callback (which only gets a void* back from C) can find its
+// way back to the right buffer.
+var (
+	errCallbacksMu sync.Mutex
+	errCallbacks   = map[*C.jq_state]*errorBuffer{}
+)
+
+//export goJQErrorCallback
+func goJQErrorCallback(data unsafe.Pointer, value C.jv) {
+	defer C.jv_free(value)
+
+	state := (*C.jq_state)(data)
+	errCallbacksMu.Lock()
+	buf := errCallbacks[state]
+	errCallbacksMu.Unlock()
+	if buf == nil {
+		return
+	}
+
+	buf.msgs = append(buf.msgs, jvErrorMessage(value))
+}
+
+// jvErrorMessage renders an error jv (usually a string, but jq sometimes
+// reports structured values) as a human-readable message.
+func jvErrorMessage(value C.jv) string {
+	if C.jv_get_kind(value) == C.JV_KIND_STRING {
+		return C.GoString(C.jv_string_value(value))
+	}
+	dumped := C.jv_dump_string(C.jv_copy(value), 0)
+	defer C.jv_free(dumped)
+	return C.GoString(C.jv_string_value(dumped))
+}
+
+// jvString builds a jv string from a Go string. jv_string copies the bytes
+// it's given, so the C string backing it is freed immediately rather than
+// leaked for the life of the jv.
+func jvString(s string) C.jv {
+	cStr := C.CString(s)
+	defer C.free(unsafe.Pointer(cStr))
+	return C.jv_string(cStr)
 }
 
 func NewJQ(program string) (*JQ, error) {
-	state := C.jq_init()
-	jq := &JQ{program, state, nil}
+	jq := newJQState(program)
 	if err := jq.compile(program); err != nil {
+		jq.Close()
+		return nil, err
+	}
+	return jq, nil
+}
+
+// NewJQWithArgs compiles program with a set of named arguments available to
+// it as jq variables, the same way the jq CLI's --arg and --argjson flags
+// bind $name inside a program. Use JQBuilder to assemble args incrementally.
+func NewJQWithArgs(program string, args map[string]interface{}) (*JQ, error) {
+	jq := newJQState(program)
+	if err := jq.compileArgs(program, argsToJv(args)); err != nil {
+		jq.Close()
 		return nil, err
 	}
 	return jq, nil
 }
 
+// newJQState initializes a jq_state and wires up its error callback, but
+// does not compile a program.
+func newJQState(program string) *JQ {
+	state := C.jq_init()
+	buf := &errorBuffer{}
+	jq := &JQ{program: program, state: state, errBuf: buf}
+
+	errCallbacksMu.Lock()
+	errCallbacks[state] = buf
+	errCallbacksMu.Unlock()
+	C.jq_set_error_cb(state, (C.jq_err_cb)(C.goJQErrorCallback), unsafe.Pointer(state))
+
+	// Safety net for callers (including JQPool) that let a JQ become
+	// unreachable without calling Close: Close clears this finalizer, so
+	// it only ever does real work on a JQ that was otherwise leaked.
+	runtime.SetFinalizer(jq, (*JQ).Close)
+
+	return jq
+}
+
+// JQBuilder assembles named program arguments (the equivalent of the jq
+// CLI's --arg and --argjson flags) before compiling a program.
+type JQBuilder struct {
+	args map[string]interface{}
+}
+
+// Arg binds name to a string value, equivalent to --arg name value.
+func (b *JQBuilder) Arg(name, value string) *JQBuilder {
+	return b.set(name, value)
+}
+
+// ArgJSON binds name to an arbitrary Go value, equivalent to
+// --argjson name value.
+func (b *JQBuilder) ArgJSON(name string, value interface{}) *JQBuilder {
+	return b.set(name, value)
+}
+
+func (b *JQBuilder) set(name string, value interface{}) *JQBuilder {
+	if b.args == nil {
+		b.args = make(map[string]interface{})
+	}
+	b.args[name] = value
+	return b
+}
+
+// Compile compiles program with the arguments accumulated so far.
+func (b *JQBuilder) Compile(program string) (*JQ, error) {
+	return NewJQWithArgs(program, b.args)
+}
+
+// Err returns the error reported by jq for the most recently produced
+// value, e.g. when a filter like `error("boom")` causes Next to return an
+// invalid result. It returns nil once Next has returned false because the
+// input was simply exhausted.
+func (jq *JQ) Err() error {
+	if jq.lastValue == nil || jq.lastValue.isValid() {
+		return nil
+	}
+	if C.jv_invalid_has_msg(C.jv_copy(jq.lastValue.value)) == 0 {
+		return nil
+	}
+	msg := C.jv_invalid_get_msg(C.jv_copy(jq.lastValue.value))
+	defer C.jv_free(msg)
+	return errors.New(jvErrorMessage(msg))
+}
+
 func (jq *JQ) Handle(value interface{}) {
 	jq.start(NewJVFromGo(value))
 }
@@ -35,12 +187,15 @@ func (jq *JQ) HandleJson(text string) {
 
 func (jq *JQ) Next() bool {
 	// FIXME this raises assertion if called before start()
+	if jq.lastValue != nil {
+		C.jv_free(jq.lastValue.value)
+	}
 	jq.lastValue = jq.next()
 	return jq.lastValue.isValid()
 }
 
 func (jq *JQ) Value() interface{} {
-	return jq.lastValue.ToGo()
+	return jvToGoOpts(jq.lastValue.value, jq.useNumber)
 }
 
 func (jq *JQ) ValueJson() string {
@@ -50,12 +205,42 @@ func (jq *JQ) ValueJson() string {
 // JQ APIs
 
 func (jq *JQ) compile(program string) error {
-	_ = C.jq_compile(jq.state, C.CString(program))
+	cProgram := C.CString(program)
+	defer C.free(unsafe.Pointer(cProgram))
+
+	jq.errBuf.msgs = nil
+	if C.jq_compile(jq.state, cProgram) == 0 {
+		return fmt.Errorf("jq: %s", jq.compileErrorMessage())
+	}
 	return nil
 }
 
-func (jq *JQ) compileArgs(program string, args *JV) {
-	C.jq_compile_args(jq.state, C.CString(program), args.value)
+func (jq *JQ) compileErrorMessage() string {
+	if len(jq.errBuf.msgs) == 0 {
+		return "compile error"
+	}
+	return strings.Join(jq.errBuf.msgs, "; ")
+}
+
+func (jq *JQ) compileArgs(program string, args C.jv) error {
+	cProgram := C.CString(program)
+	defer C.free(unsafe.Pointer(cProgram))
+
+	jq.errBuf.msgs = nil
+	if C.jq_compile_args(jq.state, cProgram, args) == 0 {
+		return fmt.Errorf("jq: %s", jq.compileErrorMessage())
+	}
+	return nil
+}
+
+// argsToJv builds the jv value jq_compile_args expects: a flat object
+// mapping each argument name directly to its value.
+func argsToJv(args map[string]interface{}) C.jv {
+	object := C.jv_object()
+	for name, value := range args {
+		object = C.jv_object_set(object, jvString(name), goToJv(value))
+	}
+	return object
 }
 
 func (jq *JQ) start(jv *JV) {
@@ -67,7 +252,22 @@ func (jq *JQ) next() *JV {
 	return &jv
 }
 
-func (jq *JQ) teardown() {
+// Close tears down the underlying jq_state. It must be called to release
+// the C resources held by jq, typically via defer right after NewJQ.
+func (jq *JQ) Close() {
+	if jq.state == nil {
+		return
+	}
+	runtime.SetFinalizer(jq, nil)
+
+	if jq.lastValue != nil {
+		C.jv_free(jq.lastValue.value)
+		jq.lastValue = nil
+	}
+
+	errCallbacksMu.Lock()
+	delete(errCallbacks, jq.state)
+	errCallbacksMu.Unlock()
 	C.jq_teardown(&jq.state)
 }
 
@@ -78,23 +278,51 @@ type JV struct {
 }
 
 func NewJV(value string) *JV {
-	parsed := C.jv_parse(C.CString(value))
-	return &JV{parsed}
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	return &JV{C.jv_parse(cValue)}
 }
 
 func NewJVFromGo(value interface{}) *JV {
 	return &JV{goToJv(value)}
 }
 
+// parseJson parses text into a raw jv, for callers that work with jv
+// values directly (e.g. tests) rather than through the JV wrapper.
+func parseJson(text string) C.jv {
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+	return C.jv_parse(cText)
+}
+
+// freeJv releases a raw jv value obtained from parseJson or goToJv.
+func freeJv(value C.jv) {
+	C.jv_free(value)
+}
+
+// refcount returns a raw jv value's current reference count.
+func refcount(value C.jv) int {
+	return int(C.jv_get_refcnt(value))
+}
+
+// dumpJson renders a raw jv value as compact JSON without consuming it.
+func dumpJson(value C.jv) string {
+	strJv := C.jv_dump_string(C.jv_copy(value), 0)
+	defer C.jv_free(strJv)
+	return C.GoString(C.jv_string_value(strJv))
+}
+
 func (jv *JV) Copy() *JV {
 	return &JV{C.jv_copy(jv.value)}
 }
 
 func (jv *JV) ToJson() string {
-	strJv := C.jv_dump_string(jv.value, 0)
-	result := C.jv_string_value(strJv)
-	C.jv_free(strJv)
-	return C.GoString(result)
+	// jv_dump_string consumes its argument, so dump a copy: jv.value is
+	// still owned by its JV and may be read again (e.g. by Next freeing
+	// the previous lastValue, or by ToGo) after this call returns.
+	strJv := C.jv_dump_string(C.jv_copy(jv.value), 0)
+	defer C.jv_free(strJv)
+	return C.GoString(C.jv_string_value(strJv))
 }
 
 func (jv *JV) ToGo() interface{} {
@@ -106,7 +334,16 @@ func goToJv(v interface{}) C.jv {
 		return C.jv_null()
 	}
 
-	value := reflect.Indirect(reflect.ValueOf(v))
+	if num, ok := v.(json.Number); ok {
+		return jvNumberFromJSON(num)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return C.jv_null()
+	}
+
+	value := reflect.Indirect(rv)
 
 	switch value.Type().Kind() {
 	case reflect.Bool:
@@ -123,13 +360,13 @@ func goToJv(v interface{}) C.jv {
 	case reflect.Float32, reflect.Float64:
 		return C.jv_number(C.double(value.Float()))
 	case reflect.String:
-		return C.jv_string(C.CString(value.String()))
+		return jvString(value.String())
 	case reflect.Array, reflect.Slice:
 		n := value.Len()
 		arr := C.jv_array_sized(C.int(n))
 		for i := 0; i < n; i++ {
 			item := goToJv(value.Index(i).Interface())
-			arr = C.jv_array_set(C.jv_copy(arr), C.int(i), item)
+			arr = C.jv_array_set(arr, C.int(i), item)
 		}
 		return arr
 	case reflect.Map:
@@ -141,14 +378,45 @@ func goToJv(v interface{}) C.jv {
 			object = C.jv_object_set(object, key, mapValue)
 		}
 		return object
+	case reflect.Struct:
+		object := C.jv_object()
+		for _, f := range cachedStructFields(value.Type()) {
+			fv, ok := fieldByIndex(value, f.index)
+			if !ok {
+				continue // nil embedded pointer
+			}
+			if f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			object = C.jv_object_set(object, jvString(f.name), goToJv(fv.Interface()))
+		}
+		return object
 	}
 
 	msg := fmt.Sprintf("unknown type for: %v", value.Interface())
 
-	return C.jv_invalid_with_msg(C.jv_string(C.CString(msg)))
+	return C.jv_invalid_with_msg(jvString(msg))
+}
+
+// jvNumberFromJSON turns a json.Number into a jv number by parsing its
+// literal text rather than round-tripping it through a float64, which would
+// silently corrupt integers above 2^53. jv has no public API for building a
+// number straight from literal text, so this goes through jv_parse instead.
+//
+// jv_parse itself only keeps the literal on jq >= 1.7; on jq 1.6 and
+// earlier it parses straight to a float64 internally, so this still loses
+// precision on integers above 2^53 against those libjq versions.
+func jvNumberFromJSON(n json.Number) C.jv {
+	return parseJson(string(n))
 }
 
 func jvToGo(value C.jv) interface{} {
+	return jvToGoOpts(value, false)
+}
+
+// jvToGoOpts is jvToGo with the option to decode numbers as json.Number
+// instead of int/float64; see JQ.UseNumber.
+func jvToGoOpts(value C.jv, useNumber bool) interface{} {
 	switch C.jv_get_kind(value) {
 	case C.JV_KIND_INVALID:
 		return errors.New("invalid")
@@ -159,6 +427,11 @@ func jvToGo(value C.jv) interface{} {
 	case C.JV_KIND_TRUE:
 		return true
 	case C.JV_KIND_NUMBER:
+		if useNumber {
+			dumped := C.jv_dump_string(C.jv_copy(value), 0)
+			defer C.jv_free(dumped)
+			return json.Number(C.GoString(C.jv_string_value(dumped)))
+		}
 		number := C.jv_number_value(value)
 		if C.jv_is_integer(value) == 0 {
 			return float64(number)
@@ -171,7 +444,7 @@ func jvToGo(value C.jv) interface{} {
 		length := C.jv_array_length(C.jv_copy(value))
 		arr := make([]interface{}, length)
 		for i := range arr {
-			arr[i] = jvToGo(C.jv_array_get(C.jv_copy(value), C.int(i)))
+			arr[i] = jvToGoOpts(C.jv_array_get(C.jv_copy(value), C.int(i)), useNumber)
 		}
 		return arr
 	case C.JV_KIND_OBJECT:
@@ -180,7 +453,7 @@ func jvToGo(value C.jv) interface{} {
 		for jv_i := C.jv_object_iter(value); C.jv_object_iter_valid(value, jv_i) != 0; jv_i = C.jv_object_iter_next(value, jv_i) {
 			k = C.jv_object_iter_key(value, jv_i)
 			v = C.jv_object_iter_value(value, jv_i)
-			result[C.GoString(C.jv_string_value(k))] = jvToGo(v)
+			result[C.GoString(C.jv_string_value(k))] = jvToGoOpts(v, useNumber)
 		}
 		return result
 	default:
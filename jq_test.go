@@ -1,6 +1,7 @@
 package jq
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"path/filepath"
@@ -44,6 +45,45 @@ func TestJQProgram(t *testing.T) {
 	equals(t, ".", jq.program)
 }
 
+func TestNewJQCompileError(t *testing.T) {
+	_, err := NewJQ("{")
+	assert(t, err != nil, "expected a compile error for an invalid program")
+}
+
+func TestNextErr(t *testing.T) {
+	jq, err := NewJQ(`error("boom")`)
+	ok(t, err)
+	defer jq.Close()
+
+	jq.HandleJson("1")
+	equals(t, false, jq.Next())
+	assert(t, jq.Err() != nil, "expected Err() to report the filter's error")
+	equals(t, "boom", jq.Err().Error())
+}
+
+func TestNewJQWithArgs(t *testing.T) {
+	jq, err := NewJQWithArgs("$name", map[string]interface{}{"name": "world"})
+	ok(t, err)
+	defer jq.Close()
+
+	jq.HandleJson("null")
+	equals(t, true, jq.Next())
+	equals(t, "world", jq.Value())
+}
+
+func TestJQBuilder(t *testing.T) {
+	jq, err := (&JQBuilder{}).
+		Arg("name", "world").
+		ArgJSON("count", 3).
+		Compile("{name: $name, count: $count}")
+	ok(t, err)
+	defer jq.Close()
+
+	jq.HandleJson("null")
+	equals(t, true, jq.Next())
+	equals(t, map[string]interface{}{"name": "world", "count": 3}, jq.Value())
+}
+
 func TestTransform(t *testing.T) {
 	jq, err := NewJQ(".")
 	ok(t, err)
@@ -268,6 +308,85 @@ func TestJVFromGoIntSlice(t *testing.T) {
 	assertGoJvConversion(t, expected, asInts)
 }
 
+// Dump options
+
+func TestValueJsonOptsPrettySorted(t *testing.T) {
+	jq, err := NewJQ(".")
+	ok(t, err)
+	defer jq.Close()
+
+	jq.HandleJson(`{"b":1,"a":2}`)
+	equals(t, true, jq.Next())
+
+	expected := "{\n  \"a\": 2,\n  \"b\": 1\n}"
+	equals(t, expected, jq.ValueJsonOpts(DumpOptions{Pretty: true, SortKeys: true, Indent: 2}))
+}
+
+func TestValueJsonIndent(t *testing.T) {
+	jq, err := NewJQ(".")
+	ok(t, err)
+	defer jq.Close()
+
+	jq.HandleJson(`{"a":1}`)
+	equals(t, true, jq.Next())
+
+	expected := "{\n>  \"a\": 1\n>}"
+	equals(t, expected, jq.ValueJsonIndent(">", "  "))
+}
+
+// json.Number
+
+func TestJVFromGoJSONNumber(t *testing.T) {
+	expected := json.Number("12345678901234567890")
+	jv := goToJv(expected)
+	actual := jvToGoOpts(jv, true)
+	freeJv(jv)
+	equals(t, expected, actual)
+}
+
+func TestJQValueUseNumber(t *testing.T) {
+	jq, err := NewJQ(".")
+	ok(t, err)
+	defer jq.Close()
+	jq.UseNumber()
+
+	jq.HandleJson("12345678901234567890")
+	equals(t, true, jq.Next())
+	equals(t, json.Number("12345678901234567890"), jq.Value())
+}
+
+// Structs
+
+type jvFromGoPerson struct {
+	Name    string `json:"name"`
+	Age     int    `json:"age,omitempty"`
+	private string
+}
+
+type jvFromGoEmployee struct {
+	jvFromGoPerson
+	Employer string `json:"employer"`
+}
+
+func TestJVFromGoStruct(t *testing.T) {
+	expected := map[string]interface{}{"name": "Alice", "age": 30}
+	assertGoJvConversion(t, expected, jvFromGoPerson{Name: "Alice", Age: 30, private: "hidden"})
+}
+
+func TestJVFromGoStructOmitEmpty(t *testing.T) {
+	expected := map[string]interface{}{"name": "Alice"}
+	assertGoJvConversion(t, expected, jvFromGoPerson{Name: "Alice"})
+}
+
+func TestJVFromGoStructEmbedded(t *testing.T) {
+	expected := map[string]interface{}{"name": "Alice", "age": 30, "employer": "Acme"}
+	employee := jvFromGoEmployee{
+		jvFromGoPerson: jvFromGoPerson{Name: "Alice", Age: 30},
+		Employer:       "Acme",
+	}
+	assertGoJvConversion(t, expected, employee)
+}
+
 // Objects
 
 func TestJVFromGoObject(t *testing.T) {
@@ -285,6 +404,32 @@ func TestJVFromGoIntPointer(t *testing.T) {
 	assertGoJvConversion(t, expected, &expected)
 }
 
+func TestJVFromGoNilPointer(t *testing.T) {
+	assertGoJvConversion(t, nil, (*int)(nil))
+}
+
+type jvFromGoOptional struct {
+	Name string `json:"name"`
+	Age  *int   `json:"age"`
+}
+
+func TestJVFromGoStructNilPointerField(t *testing.T) {
+	expected := map[string]interface{}{"name": "Alice", "age": nil}
+	assertGoJvConversion(t, expected, jvFromGoOptional{Name: "Alice"})
+}
+
+type jvFromGoLevel int
+
+type jvFromGoLeveledPerson struct {
+	jvFromGoLevel
+	Name string `json:"name"`
+}
+
+func TestJVFromGoStructEmbeddedUnexportedNonStruct(t *testing.T) {
+	expected := map[string]interface{}{"name": "Alice"}
+	assertGoJvConversion(t, expected, jvFromGoLeveledPerson{jvFromGoLevel: 1, Name: "Alice"})
+}
+
 // JSON
 
 func TestDumpJSONRefCount(t *testing.T) {
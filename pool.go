@@ -0,0 +1,60 @@
+package jq
+
+// #include <jv.h>
+import "C"
+import "sync"
+
+// Reset discards state left over from the previous input so the same
+// compiled jq_state can be reused for a new one without recompiling the
+// program. Call Handle or HandleJson afterward to feed it fresh input.
+func (jq *JQ) Reset() {
+	if jq.lastValue != nil {
+		C.jv_free(jq.lastValue.value)
+		jq.lastValue = nil
+	}
+	jq.errBuf.msgs = nil
+	jq.useNumber = false
+}
+
+// JQPool pools compiled JQ instances for a single program, so a server
+// applying the same filter to every request doesn't pay to recompile it
+// each time.
+type JQPool struct {
+	program string
+	pool    sync.Pool
+}
+
+// NewJQPool compiles program once to validate it, then returns a JQPool
+// that lazily compiles further copies as Get needs them.
+func NewJQPool(program string) (*JQPool, error) {
+	jq, err := NewJQ(program)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &JQPool{program: program}
+	p.pool.New = func() interface{} {
+		jq, err := NewJQ(p.program)
+		if err != nil {
+			// program was already validated above, so a later compile
+			// failure means jq itself is broken, not a bad filter.
+			panic(err)
+		}
+		return jq
+	}
+	p.pool.Put(jq)
+	return p, nil
+}
+
+// Get returns a JQ compiled for the pool's program, reusing one returned
+// via Put when one is available.
+func (p *JQPool) Get() *JQ {
+	return p.pool.Get().(*JQ)
+}
+
+// Put returns jq to the pool for reuse, resetting it first. Do not use jq
+// again after calling Put.
+func (p *JQPool) Put(jq *JQ) {
+	jq.Reset()
+	p.pool.Put(jq)
+}
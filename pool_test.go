@@ -0,0 +1,21 @@
+package jq
+
+import "testing"
+
+func TestJQPool(t *testing.T) {
+	pool, err := NewJQPool(".x")
+	ok(t, err)
+
+	jq := pool.Get()
+	jq.HandleJson(`{"x":1}`)
+	equals(t, true, jq.Next())
+	equals(t, 1, jq.Value())
+	equals(t, false, jq.Next())
+	pool.Put(jq)
+
+	jq2 := pool.Get()
+	jq2.HandleJson(`{"x":2}`)
+	equals(t, true, jq2.Next())
+	equals(t, 2, jq2.Value())
+	pool.Put(jq2)
+}
@@ -0,0 +1,119 @@
+package jq
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// structField describes one field of a struct type as goToJv will emit it:
+// where to find the value (index, for encoding/json-style promoted fields),
+// what key to use, and whether to skip it when empty.
+type structField struct {
+	index     []int
+	name      string
+	omitEmpty bool
+}
+
+// structFieldsCache caches the structField layout for a reflect.Type so
+// goToJv doesn't re-walk struct tags via reflection on every call, the same
+// way encoding/json caches its typeFields.
+var structFieldsCache sync.Map // map[reflect.Type][]structField
+
+func cachedStructFields(t reflect.Type) []structField {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.([]structField)
+	}
+	fields := structFieldsOf(t, nil)
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+func structFieldsOf(t reflect.Type, index []int) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // unexported
+		}
+
+		name, opts := parseJsonTag(f.Tag.Get("json"))
+		if name == "-" {
+			continue
+		}
+
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if f.PkgPath != "" && ft.Kind() != reflect.Struct {
+			continue // unexported embedded non-struct; fv.Interface() would panic
+		}
+
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fields = append(fields, structFieldsOf(ft, fieldIndex)...)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		fields = append(fields, structField{
+			index:     fieldIndex,
+			name:      name,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+	return fields
+}
+
+// parseJsonTag splits an encoding/json struct tag into its name and the
+// remainder of its comma-separated options; this module only cares about
+// "omitempty".
+func parseJsonTag(tag string) (name, opts string) {
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx], tag[idx+1:]
+	}
+	return tag, ""
+}
+
+// fieldByIndex walks a promoted-field index path, the way encoding/json
+// does, returning false if it passes through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 {
+			if v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return reflect.Value{}, false
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}